@@ -0,0 +1,214 @@
+package gomatrix
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Client represents a Matrix client.
+type Client struct {
+	HomeserverURL *url.URL
+	Prefix        string
+	UserID        string
+	AccessToken   string
+	Client        *http.Client
+	Syncer        Syncer
+	Store         Store
+
+	eventsChan chan *Event
+	listener   *defaultListener
+}
+
+// NewClient creates a new Matrix client ready to talk to homeserverURL, authenticated as userID with
+// accessToken. It wires up a DefaultSyncer and an InMemoryStore; replace Client.Syncer or Client.Store before
+// the first call to Sync to customize either.
+func NewClient(homeserverURL, userID, accessToken string) (*Client, error) {
+	hsURL, err := url.Parse(homeserverURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse homeserver URL: %s", err)
+	}
+	eventsChan := make(chan *Event, 10)
+	store := NewInMemoryStore()
+	cli := &Client{
+		HomeserverURL: hsURL,
+		Prefix:        "/_matrix/client/r0",
+		UserID:        userID,
+		AccessToken:   accessToken,
+		Client:        &http.Client{},
+		Store:         store,
+		Syncer:        NewDefaultSyncer(userID, eventsChan),
+		eventsChan:    eventsChan,
+		listener:      NewDefaultListener(eventsChan),
+	}
+	if ds, ok := cli.Syncer.(*DefaultSyncer); ok {
+		ds.Store = store
+		ds.listener = cli.listener
+	}
+	return cli, nil
+}
+
+// On registers callback to be invoked whenever an event of type eType is dispatched by the syncer.
+func (cli *Client) On(eType EventType, callback EventListenerCallback) {
+	cli.listener.On(eType, callback)
+}
+
+// OnEventSource registers callback to be invoked for any dispatched event whose EventSource matches source,
+// regardless of event type.
+func (cli *Client) OnEventSource(source EventSource, callback EventListenerCallback) {
+	cli.listener.OnEventSource(source, callback)
+}
+
+// OnParseError registers callback to be invoked whenever a raw sync event fails to decode, instead of that
+// failure aborting the whole sync.
+func (cli *Client) OnParseError(callback EventParseErrorCallback) {
+	cli.listener.OnParseError(callback)
+}
+
+// Room returns the cached Room for roomID, creating an empty one if this is the first time it has been seen.
+// The Room is populated as m.room.* state events arrive for it via Sync.
+func (cli *Client) Room(roomID string) *Room {
+	return cli.Store.GetOrCreateRoom(roomID)
+}
+
+// CreateFilter uploads filter to the homeserver and returns the filter ID it was assigned, for reuse across
+// syncs instead of sending the filter JSON on every /sync request.
+func (cli *Client) CreateFilter(filter *Filter) (string, error) {
+	body, err := json.Marshal(filter)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal filter: %s", err)
+	}
+
+	u := *cli.HomeserverURL
+	u.Path = cli.Prefix + "/user/" + url.PathEscape(cli.UserID) + "/filter"
+
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to build filter request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cli.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := cli.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("filter request failed: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return "", fmt.Errorf("filter request returned HTTP %d", res.StatusCode)
+	}
+
+	var respBody struct {
+		FilterID string `json:"filter_id"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&respBody); err != nil {
+		return "", fmt.Errorf("failed to decode filter response: %s", err)
+	}
+	return respBody.FilterID, nil
+}
+
+// Sync starts syncing with the provided context, blocking until the context is cancelled, the Syncer's
+// OnFailedSync returns an error, or ProcessResponse returns an error. Callers typically invoke Sync in a loop,
+// since a single call returns whenever the sync is interrupted.
+func (cli *Client) Sync(ctx context.Context) error {
+	// Hand the current Client.Store to the DefaultSyncer, so Room state lands where Client.Room reads it even if
+	// Client.Store was replaced after NewClient.
+	if ds, ok := cli.Syncer.(*DefaultSyncer); ok {
+		ds.Store = cli.Store
+	}
+
+	// The listener runs under its own context, cancelled when this call returns, so a later call to Sync starts
+	// a fresh listener instead of finding the previous one stopped for good.
+	listenerCtx, stopListener := context.WithCancel(ctx)
+	defer stopListener()
+	listenerErrCh := make(chan error, 1)
+	go func() {
+		listenerErrCh <- cli.listener.scanEvents(listenerCtx)
+	}()
+
+	filterID := cli.Store.LoadFilterID(cli.UserID)
+	if filterID == "" {
+		filter := new(Filter)
+		if err := json.Unmarshal(cli.Syncer.GetFilterJSON(cli.UserID), filter); err != nil {
+			return fmt.Errorf("failed to decode GetFilterJSON output as a Filter: %s", err)
+		}
+		id, err := cli.CreateFilter(filter)
+		if err != nil {
+			return err
+		}
+		filterID = id
+		cli.Store.SaveFilterID(cli.UserID, filterID)
+	}
+
+	for {
+		since := cli.Store.LoadNextBatch(cli.UserID)
+		resp, err := cli.syncHTTP(ctx, since, filterID)
+		if err != nil {
+			duration, ferr := cli.Syncer.OnFailedSync(resp, err)
+			if ferr != nil {
+				return ferr
+			}
+			select {
+			case <-time.After(duration):
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		if err = cli.Syncer.ProcessResponse(ctx, resp, since); err != nil {
+			return err
+		}
+		cli.Store.SaveNextBatch(cli.UserID, resp.NextBatch)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case lerr := <-listenerErrCh:
+			return lerr
+		default:
+		}
+	}
+}
+
+// syncHTTP performs a single /sync request and decodes the response.
+func (cli *Client) syncHTTP(ctx context.Context, since, filterID string) (*RespSync, error) {
+	query := url.Values{
+		"timeout": []string{strconv.Itoa(30000)},
+		"filter":  []string{filterID},
+	}
+	if since != "" {
+		query.Set("since", since)
+	}
+	u := *cli.HomeserverURL
+	u.Path = cli.Prefix + "/sync"
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build sync request: %s", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cli.AccessToken)
+
+	res, err := cli.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sync request failed: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("sync request returned HTTP %d", res.StatusCode)
+	}
+
+	var resp RespSync
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to decode sync response: %s", err)
+	}
+	return &resp, nil
+}
@@ -11,7 +11,7 @@ func TestClient_Sync(t *testing.T) {
 	cli, _ := NewClient("https://matrix.org", "@example:matrix.org", "MDAefhiuwehfuiwe")
 	cli.Store.SaveFilterID("@example:matrix.org", "2")                // Optional: if you know it already
 	cli.Store.SaveNextBatch("@example:matrix.org", "111_222_333_444") // Optional: if you know it already
-	cli.On(MessageEventType, func(ev *Event) {
+	cli.On(MessageEventType, func(ctx context.Context, ev *Event) {
 		fmt.Println("Message: ", ev)
 	})
 
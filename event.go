@@ -0,0 +1,26 @@
+package gomatrix
+
+// EventType is the `type` field of a Matrix event, e.g. "m.room.message" or "m.room.member".
+type EventType string
+
+const (
+	// MessageEventType is the type of an m.room.message event, an ordinary chat message.
+	MessageEventType EventType = "m.room.message"
+	// MemberEventType is the type of an m.room.member state event, tracking a single user's membership in a room.
+	MemberEventType EventType = "m.room.member"
+)
+
+// Event represents a single Matrix event, state or otherwise, as it appears in a /sync response.
+type Event struct {
+	ID        string                 `json:"event_id,omitempty"`
+	Type      EventType              `json:"type"`
+	RoomID    string                 `json:"room_id,omitempty"`
+	Sender    string                 `json:"sender"`
+	StateKey  *string                `json:"state_key,omitempty"`
+	Content   map[string]interface{} `json:"content"`
+	Timestamp int64                  `json:"origin_server_ts,omitempty"`
+
+	// Source records which part of a /sync response this Event was dispatched from (e.g. Join|Timeline). It is
+	// set by DefaultSyncer.ProcessResponse, not decoded from JSON.
+	Source EventSource `json:"-"`
+}
@@ -2,6 +2,7 @@ package gomatrix
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"runtime/debug"
 	"sync"
@@ -9,6 +10,13 @@ import (
 
 type EventListener interface {
 	On(eType EventType, callback EventListenerCallback)
+	// OnEventSource registers a callback that fires for any event whose EventSource has at least one bit in
+	// common with source, regardless of event type. Useful for distinguishing e.g. a m.room.member state event
+	// from the same event type arriving via the timeline.
+	OnEventSource(source EventSource, callback EventListenerCallback)
+	// OnParseError registers a callback that fires whenever a raw sync event fails to decode into an Event,
+	// instead of that failure aborting the whole sync the way a top-level json.Unmarshal would.
+	OnParseError(callback EventParseErrorCallback)
 
 	looper
 }
@@ -18,8 +26,10 @@ type looper interface {
 	stop()
 }
 
-// EventListenerCallback can be used with DefaultSyncer.OnEventType to be informed of incoming events.
-type EventListenerCallback func(*Event)
+// EventListenerCallback can be used with Client.On or Client.OnEventSource to be informed of incoming events.
+// The context is derived from the one passed to Sync and is cancelled when that Sync call returns or its
+// deadline expires, so long-running callbacks should select on ctx.Done().
+type EventListenerCallback func(ctx context.Context, ev *Event)
 
 func NewDefaultListener(eventsChan <-chan *Event) *defaultListener {
 	dl := &defaultListener{
@@ -30,12 +40,20 @@ func NewDefaultListener(eventsChan <-chan *Event) *defaultListener {
 	return dl
 }
 
+type sourceListener struct {
+	source   EventSource
+	callback EventListenerCallback
+}
+
 type defaultListener struct {
-	listeners      map[EventType][]EventListenerCallback
-	listenersRWMut sync.RWMutex
-	stopCh         chan struct{}
-	events         <-chan *Event
-	err            error
+	listeners       map[EventType][]EventListenerCallback
+	sourceListeners []sourceListener
+	parseErrorCbs   []EventParseErrorCallback
+	listenersRWMut  sync.RWMutex
+	stopCh          chan struct{}
+	stopOnce        sync.Once
+	events          <-chan *Event
+	err             error
 }
 
 func (l *defaultListener) On(eType EventType, callback EventListenerCallback) {
@@ -49,15 +67,44 @@ func (l *defaultListener) On(eType EventType, callback EventListenerCallback) {
 	l.listenersRWMut.Unlock()
 }
 
-func (l *defaultListener) call(e *Event) (err error) {
+func (l *defaultListener) OnEventSource(source EventSource, callback EventListenerCallback) {
+	l.listenersRWMut.Lock()
+	l.sourceListeners = append(l.sourceListeners, sourceListener{source: source, callback: callback})
+	l.listenersRWMut.Unlock()
+}
+
+func (l *defaultListener) OnParseError(callback EventParseErrorCallback) {
+	l.listenersRWMut.Lock()
+	l.parseErrorCbs = append(l.parseErrorCbs, callback)
+	l.listenersRWMut.Unlock()
+}
+
+// callParseError is invoked by DefaultSyncer, not via the events channel, since a raw event that failed to
+// decode never becomes an *Event to dispatch.
+func (l *defaultListener) callParseError(roomID string, raw json.RawMessage, err error) {
+	l.listenersRWMut.RLock()
+	cbs := l.parseErrorCbs
+	l.listenersRWMut.RUnlock()
+	for _, cb := range cbs {
+		cb(roomID, raw, err)
+	}
+}
+
+func (l *defaultListener) call(ctx context.Context, e *Event) (err error) {
 	if e == nil {
 		return
 	}
 
 	l.listenersRWMut.RLock()
-	callsList, ok := l.listeners[e.Type]
-	l.listenersRWMut.Unlock()
-	if !ok {
+	callsList := l.listeners[e.Type]
+	var sourceCallsList []sourceListener
+	for _, sl := range l.sourceListeners {
+		if sl.source&e.Source != 0 {
+			sourceCallsList = append(sourceCallsList, sl)
+		}
+	}
+	l.listenersRWMut.RUnlock()
+	if len(callsList) == 0 && len(sourceCallsList) == 0 {
 		return
 	}
 
@@ -68,7 +115,10 @@ func (l *defaultListener) call(e *Event) (err error) {
 	}()
 
 	for _, fn := range callsList {
-		fn(e)
+		fn(ctx, e)
+	}
+	for _, sl := range sourceCallsList {
+		sl.callback(ctx, e)
 	}
 	return
 }
@@ -80,7 +130,7 @@ func (l *defaultListener) scanEvents(ctx context.Context) error {
 			if !ok {
 				return l.err
 			}
-			if l.err = l.call(e); l.err != nil {
+			if l.err = l.call(ctx, e); l.err != nil {
 				return l.err
 			}
 		case <-l.stopCh:
@@ -91,6 +141,12 @@ func (l *defaultListener) scanEvents(ctx context.Context) error {
 	}
 }
 
+// stop signals scanEvents to return. It closes stopCh rather than sending on it, and is safe to call more than
+// once or after scanEvents has already returned (e.g. via ctx cancellation) — a blocking send here would
+// deadlock forever once nothing is left to receive it. A stopped listener stays stopped: any later scanEvents
+// call returns at once.
 func (l *defaultListener) stop() {
-	l.stopCh <- struct{}{}
+	l.stopOnce.Do(func() {
+		close(l.stopCh)
+	})
 }
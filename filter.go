@@ -0,0 +1,51 @@
+package gomatrix
+
+// EventFilter narrows which events a filter sub-section returns, matching the event-filtering fields of the
+// Matrix filter schema (see the Client-Server spec's "Filtering" section). It is used for the top-level
+// Filter.Presence/Filter.AccountData sections and, nested under RoomFilter, for room.state/room.timeline/
+// room.ephemeral/room.account_data.
+type EventFilter struct {
+	// Limit caps how many events are returned. A homeserver may apply its own (lower) limit regardless.
+	Limit int `json:"limit,omitempty"`
+	// Types, if non-empty, restricts results to events whose type matches one of these (wildcards allowed).
+	Types []string `json:"types,omitempty"`
+	// NotTypes excludes events whose type matches one of these; takes precedence over Types.
+	NotTypes []string `json:"not_types,omitempty"`
+	// Senders, if non-empty, restricts results to events from one of these senders.
+	Senders []string `json:"senders,omitempty"`
+	// NotSenders excludes events from one of these senders; takes precedence over Senders.
+	NotSenders []string `json:"not_senders,omitempty"`
+	// LazyLoadMembers, if true, only returns membership events needed to display the senders of events in the
+	// response, instead of the full member list of every room. Only meaningful on RoomFilter.State.
+	LazyLoadMembers bool `json:"lazy_load_members,omitempty"`
+	// IncludeRedundantMembers disables the deduplication LazyLoadMembers otherwise applies across consecutive
+	// syncs. Only meaningful on RoomFilter.State.
+	IncludeRedundantMembers bool `json:"include_redundant_members,omitempty"`
+	// ContainsURL, if true, only returns events with a url key in their content.
+	ContainsURL bool `json:"contains_url,omitempty"`
+}
+
+// RoomFilter narrows which rooms, and which events within them, a /sync response includes. The Matrix filter
+// schema nests event-filtering fields under room.state/room.timeline/room.ephemeral/room.account_data rather
+// than directly under room, since state, timeline and ephemeral events need independent limits and type lists.
+type RoomFilter struct {
+	// Rooms, if non-empty, restricts results to one of these rooms.
+	Rooms []string `json:"rooms,omitempty"`
+	// NotRooms excludes one of these rooms; takes precedence over Rooms.
+	NotRooms []string `json:"not_rooms,omitempty"`
+	// IncludeLeave, if true, includes rooms the user has left in the response.
+	IncludeLeave bool `json:"include_leave,omitempty"`
+
+	State       EventFilter `json:"state,omitempty"`
+	Timeline    EventFilter `json:"timeline,omitempty"`
+	Ephemeral   EventFilter `json:"ephemeral,omitempty"`
+	AccountData EventFilter `json:"account_data,omitempty"`
+}
+
+// Filter describes a Matrix /sync filter. Set DefaultSyncer.Filter to have it marshalled by GetFilterJSON, or
+// build one and call Client.CreateFilter to upload it ahead of time and reuse the returned filter ID.
+type Filter struct {
+	Room        RoomFilter  `json:"room,omitempty"`
+	Presence    EventFilter `json:"presence,omitempty"`
+	AccountData EventFilter `json:"account_data,omitempty"`
+}
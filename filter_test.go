@@ -0,0 +1,70 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDefaultSyncer_GetFilterJSON_DefaultsToRoomTimelineLimit(t *testing.T) {
+	s := NewDefaultSyncer("@bot:example.org", make(chan *Event))
+
+	var got Filter
+	if err := json.Unmarshal(s.GetFilterJSON("@bot:example.org"), &got); err != nil {
+		t.Fatalf("failed to unmarshal default filter JSON: %s", err)
+	}
+	if got.Room.Timeline.Limit != 50 {
+		t.Fatalf("want default room timeline limit of 50, got %d", got.Room.Timeline.Limit)
+	}
+}
+
+// TestDefaultSyncer_GetFilterJSON_DefaultMatchesWireShape checks the actual JSON shape rather than round-tripping
+// through Filter, since a symmetrical bug (e.g. a flattened room.limit instead of room.timeline.limit) would
+// round-trip cleanly through the package's own types while still being silently ignored by a real homeserver.
+func TestDefaultSyncer_GetFilterJSON_DefaultMatchesWireShape(t *testing.T) {
+	s := NewDefaultSyncer("@bot:example.org", make(chan *Event))
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(s.GetFilterJSON("@bot:example.org"), &got); err != nil {
+		t.Fatalf("failed to unmarshal default filter JSON: %s", err)
+	}
+	room, ok := got["room"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("want a \"room\" object, got %+v", got)
+	}
+	if _, ok := room["limit"]; ok {
+		t.Fatalf("room.limit is not part of the /sync filter wire schema and would be silently ignored, got room=%+v", room)
+	}
+	timeline, ok := room["timeline"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("want room.timeline to carry the limit, got room=%+v", room)
+	}
+	if timeline["limit"] != float64(50) {
+		t.Fatalf("want room.timeline.limit of 50, got %v", timeline["limit"])
+	}
+}
+
+func TestDefaultSyncer_GetFilterJSON_UsesConfiguredFilter(t *testing.T) {
+	s := NewDefaultSyncer("@bot:example.org", make(chan *Event))
+	s.Filter = &Filter{
+		Room: RoomFilter{
+			Timeline: EventFilter{
+				Limit: 10,
+				Types: []string{"m.room.message"},
+			},
+			State: EventFilter{
+				LazyLoadMembers: true,
+			},
+		},
+	}
+
+	var got Filter
+	if err := json.Unmarshal(s.GetFilterJSON("@bot:example.org"), &got); err != nil {
+		t.Fatalf("failed to unmarshal configured filter JSON: %s", err)
+	}
+	if got.Room.Timeline.Limit != 10 || len(got.Room.Timeline.Types) != 1 || got.Room.Timeline.Types[0] != "m.room.message" {
+		t.Fatalf("configured timeline filter not round-tripped correctly, got %+v", got.Room.Timeline)
+	}
+	if !got.Room.State.LazyLoadMembers {
+		t.Fatalf("configured state filter not round-tripped correctly, got %+v", got.Room.State)
+	}
+}
@@ -0,0 +1,28 @@
+package gomatrix
+
+import "encoding/json"
+
+// EventParseErrorCallback is invoked whenever a single raw sync event fails to decode into an Event, so bots
+// can log or quarantine it instead of losing the rest of the response.
+type EventParseErrorCallback func(roomID string, raw json.RawMessage, err error)
+
+// parseEvents decodes each raw message into an Event tagged with roomID. A message that fails to decode is
+// skipped and reported via onParseError (if non-nil) rather than aborting the rest of the batch.
+func parseEvents(roomID string, raw []json.RawMessage, onParseError EventParseErrorCallback) []Event {
+	if len(raw) == 0 {
+		return nil
+	}
+	events := make([]Event, 0, len(raw))
+	for _, r := range raw {
+		var e Event
+		if err := json.Unmarshal(r, &e); err != nil {
+			if onParseError != nil {
+				onParseError(roomID, r, err)
+			}
+			continue
+		}
+		e.RoomID = roomID
+		events = append(events, e)
+	}
+	return events
+}
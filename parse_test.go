@@ -0,0 +1,42 @@
+package gomatrix
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseEvents_SkipsAndReportsMalformedEvents(t *testing.T) {
+	raw := []json.RawMessage{
+		json.RawMessage(`{"type":"m.room.message","sender":"@alice:example.org"}`),
+		json.RawMessage(`not valid json`),
+		json.RawMessage(`{"type":"m.room.message","sender":"@bob:example.org"}`),
+	}
+
+	var reported []string
+	onParseError := func(roomID string, raw json.RawMessage, err error) {
+		reported = append(reported, roomID)
+	}
+
+	events := parseEvents("!room:example.org", raw, onParseError)
+
+	if len(events) != 2 {
+		t.Fatalf("want 2 successfully decoded events, got %d", len(events))
+	}
+	if len(reported) != 1 {
+		t.Fatalf("want 1 parse error reported, got %d", len(reported))
+	}
+	if reported[0] != "!room:example.org" {
+		t.Fatalf("want parse error reported for !room:example.org, got %s", reported[0])
+	}
+	for _, e := range events {
+		if e.RoomID != "!room:example.org" {
+			t.Fatalf("want decoded event tagged with RoomID, got %q", e.RoomID)
+		}
+	}
+}
+
+func TestParseEvents_EmptyInputReturnsNil(t *testing.T) {
+	if events := parseEvents("!room:example.org", nil, nil); events != nil {
+		t.Fatalf("want nil for empty input, got %+v", events)
+	}
+}
@@ -0,0 +1,53 @@
+package gomatrix
+
+import "encoding/json"
+
+// RespSync is the response to a /sync request. Event payloads are kept as json.RawMessage rather than decoded
+// Events, so a single malformed event cannot fail the decode of the whole response; DefaultSyncer.ProcessResponse
+// decodes each one individually and reports failures via EventListener.OnParseError instead.
+type RespSync struct {
+	NextBatch   string `json:"next_batch"`
+	AccountData struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"account_data"`
+	Presence struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"presence"`
+	Rooms struct {
+		Join   map[string]SyncJoinRoom   `json:"join"`
+		Invite map[string]SyncInviteRoom `json:"invite"`
+		Leave  map[string]SyncLeaveRoom  `json:"leave"`
+	} `json:"rooms"`
+}
+
+// SyncJoinRoom holds the raw state, timeline and ephemeral events for a room the user is joined to.
+type SyncJoinRoom struct {
+	State struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"state"`
+	Timeline struct {
+		Events    []json.RawMessage `json:"events"`
+		Limited   bool              `json:"limited"`
+		PrevBatch string            `json:"prev_batch"`
+	} `json:"timeline"`
+	Ephemeral struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"ephemeral"`
+}
+
+// SyncInviteRoom holds the raw invite-state events for a room the user has been invited to.
+type SyncInviteRoom struct {
+	State struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"invite_state"`
+}
+
+// SyncLeaveRoom holds the raw state and timeline events for a room the user has left.
+type SyncLeaveRoom struct {
+	State struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"state"`
+	Timeline struct {
+		Events []json.RawMessage `json:"events"`
+	} `json:"timeline"`
+}
@@ -0,0 +1,57 @@
+package gomatrix
+
+import "sync"
+
+// Room holds cached room state built up by applying m.room.* state events as they arrive from /sync, so bots
+// can answer questions like "who is in this room" or "what's the room name" without an extra /state fetch per
+// event. A Room is safe for concurrent use, since UpdateState may be called from a different goroutine per
+// room by DefaultSyncer's worker pool while GetStateEvent/GetMember are called from event listeners.
+type Room struct {
+	RoomID string
+
+	mutex sync.RWMutex
+	state map[EventType]map[string]*Event
+}
+
+// NewRoom returns an empty Room ready to have state events applied to it via UpdateState.
+func NewRoom(roomID string) *Room {
+	return &Room{
+		RoomID: roomID,
+		state:  make(map[EventType]map[string]*Event),
+	}
+}
+
+// UpdateState applies a single state event to the room's cached state, keyed by event type and state key.
+// Events without a StateKey are ignored, since they are not state events and cannot be addressed by
+// GetStateEvent.
+func (r *Room) UpdateState(event *Event) {
+	if event == nil || event.StateKey == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	byKey, ok := r.state[event.Type]
+	if !ok {
+		byKey = make(map[string]*Event)
+		r.state[event.Type] = byKey
+	}
+	byKey[*event.StateKey] = event
+}
+
+// GetStateEvent returns the cached state event of the given type and state key, or nil if the room has no such
+// state cached yet.
+func (r *Room) GetStateEvent(eventType EventType, stateKey string) *Event {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	byKey, ok := r.state[eventType]
+	if !ok {
+		return nil
+	}
+	return byKey[stateKey]
+}
+
+// GetMember returns the cached m.room.member event for userID, or nil if the room has no membership state for
+// that user yet.
+func (r *Room) GetMember(userID string) *Event {
+	return r.GetStateEvent(MemberEventType, userID)
+}
@@ -0,0 +1,79 @@
+package gomatrix
+
+import "sync"
+
+// Store is an interface that must be satisfied to store client data. Some data is persisted beyond the
+// lifetime of a Client, such as the since token and any filter ID created, so that subsequent calls to Sync
+// can resume where a previous process left off. Implementations are also responsible for caching per-room
+// state via GetOrCreateRoom; see Room.
+type Store interface {
+	SaveFilterID(userID, filterID string)
+	LoadFilterID(userID string) string
+	SaveNextBatch(userID, nextBatch string)
+	LoadNextBatch(userID string) string
+
+	// GetOrCreateRoom returns the cached Room for roomID, creating an empty one the first time roomID is seen.
+	GetOrCreateRoom(roomID string) *Room
+	// GetRoom returns the cached Room for roomID, or nil if GetOrCreateRoom has never been called for roomID.
+	GetRoom(roomID string) *Room
+}
+
+// InMemoryStore implements the Store interface. It is the default storage backing for a Client: everything is
+// lost when the process exits, so bots that need to resume a since token (or cached room state) across
+// restarts should provide their own persistent Store instead.
+type InMemoryStore struct {
+	mutex     sync.RWMutex
+	filters   map[string]string
+	nextBatch map[string]string
+	rooms     map[string]*Room
+}
+
+// NewInMemoryStore constructs a new InMemoryStore.
+func NewInMemoryStore() *InMemoryStore {
+	return &InMemoryStore{
+		filters:   make(map[string]string),
+		nextBatch: make(map[string]string),
+		rooms:     make(map[string]*Room),
+	}
+}
+
+func (s *InMemoryStore) SaveFilterID(userID, filterID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.filters[userID] = filterID
+}
+
+func (s *InMemoryStore) LoadFilterID(userID string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.filters[userID]
+}
+
+func (s *InMemoryStore) SaveNextBatch(userID, nextBatch string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.nextBatch[userID] = nextBatch
+}
+
+func (s *InMemoryStore) LoadNextBatch(userID string) string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.nextBatch[userID]
+}
+
+func (s *InMemoryStore) GetOrCreateRoom(roomID string) *Room {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	room, ok := s.rooms[roomID]
+	if !ok {
+		room = NewRoom(roomID)
+		s.rooms[roomID] = room
+	}
+	return room
+}
+
+func (s *InMemoryStore) GetRoom(roomID string) *Room {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.rooms[roomID]
+}
@@ -1,9 +1,11 @@
 package gomatrix
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"runtime/debug"
+	"sync"
 	"time"
 )
 
@@ -12,19 +14,104 @@ type Syncer interface {
 	// ProcessResponse process the /sync response. The since parameter is the since= value that was used to produce the response.
 	// This is useful for detecting the very first sync (since=""). If an error is return, Syncing will be stopped
 	// permanently.
-	ProcessResponse(resp *RespSync, since string) error
+	ProcessResponse(ctx context.Context, resp *RespSync, since string) error
 	// OnFailedSync returns either the time to wait before retrying or an error to stop syncing permanently.
 	OnFailedSync(res *RespSync, err error) (time.Duration, error)
 	// GetFilterJSON for the given user ID. NOT the filter ID.
 	GetFilterJSON(userID string) json.RawMessage
 }
 
+// ExtensibleSyncer is a Syncer that additionally allows callers to register SyncHandler middleware that sees
+// the whole /sync response before any per-event dispatch happens. This is useful for bots that need to act on
+// the response as a whole (e.g. batching, metrics, custom room bookkeeping) without reimplementing ProcessResponse.
+type ExtensibleSyncer interface {
+	Syncer
+	// AddSyncHandler registers a SyncHandler that will be invoked, in registration order, on every processed
+	// /sync response before its events are dispatched to per-event listeners.
+	AddSyncHandler(handler SyncHandler)
+}
+
+// SyncHandler is invoked with a fully parsed /sync response before DefaultSyncer dispatches its events to any
+// per-event listeners. Returning false aborts any remaining SyncHandlers and skips per-event dispatch entirely
+// for this response.
+type SyncHandler func(ctx context.Context, resp *RespSync, since string) bool
+
+// EventSource is a bitmask describing which part of a /sync response an Event was dispatched from, e.g. a
+// m.room.member event can arrive via State or Timeline, and listeners often need to tell the two apart.
+type EventSource int
+
+const (
+	Presence EventSource = 1 << iota
+	AccountData
+	Join
+	Invite
+	Leave
+	Timeline
+	State
+	Ephemeral
+	ToDevice
+)
+
+// DefaultWorkers and DefaultQueueSize are the pool settings DefaultSyncer falls back to when Workers or
+// QueueSize are left at their zero value.
+const (
+	DefaultWorkers   = 256
+	DefaultQueueSize = 2048
+)
+
+// JoinRaceStrategy controls how DefaultSyncer handles the "join race": /sync returning timeline events for a
+// room from before the bot's own join, because the join happened to land in the same response as a batch of
+// historical messages.
+type JoinRaceStrategy int
+
+const (
+	// DropUpToJoin discards timeline events at-or-before the most recent self-join m.room.member event for a
+	// room, keeping anything that arrived after it. This is the default: it is the only strategy that does not
+	// either lose legitimate post-join messages or risk re-processing pre-join history.
+	DropUpToJoin JoinRaceStrategy = iota
+	// DropRoom discards the room entirely for this response if a self-join appears anywhere in its timeline.
+	// This is the old, overly broad behavior, kept for callers that relied on it.
+	DropRoom
+	// ProcessAll disables join-race handling altogether; every event is dispatched as received.
+	ProcessAll
+)
+
 // DefaultSyncer is the default syncing implementation. You can either write your own syncer, or selectively
 // replace parts of this default syncer (e.g. the ProcessResponse method). The default syncer uses the observer
 // pattern to notify callers about incoming events. See DefaultSyncer.OnEventType for more information.
 type DefaultSyncer struct {
-	UserID     string
-	eventsChan chan<- *Event
+	UserID       string
+	eventsChan   chan<- *Event
+	syncHandlers []SyncHandler
+
+	// Store, if set, receives every state event via Store.GetOrCreateRoom(roomID).UpdateState before that
+	// event is dispatched to listeners, so Room state is always caught up by the time a listener sees it.
+	Store Store
+
+	// Filter, if set, is what GetFilterJSON marshals and returns. Leave nil to fall back to the default filter
+	// (a room timeline limited to 50 events).
+	Filter *Filter
+
+	// JoinRaceStrategy controls how a self-join landing in the same /sync response as timeline history is
+	// handled. Defaults to DropUpToJoin.
+	JoinRaceStrategy JoinRaceStrategy
+	// IgnoreOwnEvents, if true, skips dispatching timeline events sent by UserID, so a bot does not see its own
+	// messages echoed back to it.
+	IgnoreOwnEvents bool
+
+	// Workers caps how many rooms can be processed concurrently by ProcessResponse. Defaults to DefaultWorkers.
+	Workers int
+	// QueueSize caps how many per-room tasks may be buffered before ProcessResponse blocks. Defaults to
+	// DefaultQueueSize.
+	QueueSize int
+
+	pool     *roomTaskPool
+	poolOnce sync.Once
+
+	// listener, if set by Client, is reported to whenever a raw event fails to decode, via its
+	// EventListener.OnParseError callbacks. DefaultSyncer instances built without a Client (e.g. in tests)
+	// simply skip parse-error reporting.
+	listener *defaultListener
 }
 
 // NewDefaultSyncer returns an instantiated DefaultSyncer
@@ -35,10 +122,66 @@ func NewDefaultSyncer(userID string, eventsChan chan<- *Event) *DefaultSyncer {
 	}
 }
 
+// AddSyncHandler registers a SyncHandler to be run, in registration order, on every response ProcessResponse
+// accepts, before any events from that response are sent to per-event listeners.
+func (s *DefaultSyncer) AddSyncHandler(handler SyncHandler) {
+	s.syncHandlers = append(s.syncHandlers, handler)
+}
+
+// roomPool lazily creates the worker pool that backs per-room fan-out in ProcessResponse, applying
+// DefaultWorkers/DefaultQueueSize if Workers/QueueSize were never set.
+func (s *DefaultSyncer) roomPool() *roomTaskPool {
+	s.poolOnce.Do(func() {
+		workers := s.Workers
+		if workers <= 0 {
+			workers = DefaultWorkers
+		}
+		queueSize := s.QueueSize
+		if queueSize <= 0 {
+			queueSize = DefaultQueueSize
+		}
+		s.pool = newRoomTaskPool(workers, queueSize)
+	})
+	return s.pool
+}
+
+// Stop shuts down the per-room worker pool started by ProcessResponse, waiting for queued and in-flight room
+// tasks to finish. Safe to call even if ProcessResponse was never called.
+func (s *DefaultSyncer) Stop() {
+	s.roomPool().stop()
+}
+
+// decodedJoinRoom is a SyncJoinRoom with every event already decoded from its raw form.
+type decodedJoinRoom struct {
+	state     []Event
+	timeline  []Event
+	ephemeral []Event
+}
+
+// decodedInviteRoom is a SyncInviteRoom with every event already decoded from its raw form.
+type decodedInviteRoom struct {
+	state []Event
+}
+
+// decodedLeaveRoom is a SyncLeaveRoom with every event already decoded from its raw form.
+type decodedLeaveRoom struct {
+	state    []Event
+	timeline []Event
+}
+
+// parseErrorCallback returns the callback DefaultSyncer should report decode failures to, or nil if no
+// listener has been wired up (e.g. a DefaultSyncer constructed directly, outside of a Client).
+func (s *DefaultSyncer) parseErrorCallback() EventParseErrorCallback {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.callParseError
+}
+
 // ProcessResponse processes the /sync response in a way suitable for bots. "Suitable for bots" means a stream of
 // unrepeating events. Returns a fatal error if a listener panics.
-func (s *DefaultSyncer) ProcessResponse(res *RespSync, since string) (err error) {
-	if !s.shouldProcessResponse(res, since) {
+func (s *DefaultSyncer) ProcessResponse(ctx context.Context, res *RespSync, since string) (err error) {
+	if since == "" {
 		return
 	}
 
@@ -48,77 +191,214 @@ func (s *DefaultSyncer) ProcessResponse(res *RespSync, since string) (err error)
 		}
 	}()
 
-	for _, e := range res.AccountData.Events {
-		s.eventsChan <- &e
-	}
-	for _, e := range res.Presence.Events {
-		s.eventsChan <- &e
+	for _, handler := range s.syncHandlers {
+		if !handler(ctx, res, since) {
+			return
+		}
 	}
 
-	for roomID, roomData := range res.Rooms.Join {
-		for _, event := range roomData.State.Events {
-			event.RoomID = roomID
-			s.eventsChan <- &event
+	onParseError := s.parseErrorCallback()
+
+	accountData := parseEvents("", res.AccountData.Events, onParseError)
+	presence := parseEvents("", res.Presence.Events, onParseError)
+
+	joinRooms := make(map[string]*decodedJoinRoom, len(res.Rooms.Join))
+	for roomID, room := range res.Rooms.Join {
+		joinRooms[roomID] = &decodedJoinRoom{
+			state:     parseEvents(roomID, room.State.Events, onParseError),
+			timeline:  parseEvents(roomID, room.Timeline.Events, onParseError),
+			ephemeral: parseEvents(roomID, room.Ephemeral.Events, onParseError),
 		}
-		for _, event := range roomData.Timeline.Events {
-			event.RoomID = roomID
-			s.eventsChan <- &event
+	}
+	inviteRooms := make(map[string]*decodedInviteRoom, len(res.Rooms.Invite))
+	for roomID, room := range res.Rooms.Invite {
+		inviteRooms[roomID] = &decodedInviteRoom{state: parseEvents(roomID, room.State.Events, onParseError)}
+	}
+	leaveRooms := make(map[string]*decodedLeaveRoom, len(res.Rooms.Leave))
+	for roomID, room := range res.Rooms.Leave {
+		leaveRooms[roomID] = &decodedLeaveRoom{
+			state:    parseEvents(roomID, room.State.Events, onParseError),
+			timeline: parseEvents(roomID, room.Timeline.Events, onParseError),
 		}
-		for _, event := range roomData.Ephemeral.Events {
-			event.RoomID = roomID
-			s.eventsChan <- &event
+	}
+
+	s.applyJoinRaceStrategy(joinRooms, inviteRooms)
+
+	for _, e := range accountData {
+		e := e
+		e.Source = AccountData
+		if !s.sendEvent(ctx, &e) {
+			return ctx.Err()
 		}
 	}
-	for roomID, roomData := range res.Rooms.Invite {
-		for _, event := range roomData.State.Events {
-			event.RoomID = roomID
-			s.eventsChan <- &event
+	for _, e := range presence {
+		e := e
+		e.Source = Presence
+		if !s.sendEvent(ctx, &e) {
+			return ctx.Err()
 		}
 	}
-	for roomID, roomData := range res.Rooms.Leave {
-		for _, event := range roomData.Timeline.Events {
-			event.RoomID = roomID
-			s.eventsChan <- &event
+
+	var roomWG sync.WaitGroup
+	var firstRoomErr error
+	var firstRoomErrOnce sync.Once
+	pool := s.roomPool()
+
+	dispatchRoom := func(fn func()) {
+		roomWG.Add(1)
+		if !pool.submit(ctx, func() {
+			defer roomWG.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					firstRoomErrOnce.Do(func() {
+						firstRoomErr = fmt.Errorf("ProcessResponse room task panicked! userID=%s since=%s panic=%s\n%s", s.UserID, since, r, debug.Stack())
+					})
+				}
+			}()
+			fn()
+		}) {
+			roomWG.Done()
 		}
 	}
-	return
-}
 
-// shouldProcessResponse returns true if the response should be processed. May modify the response to remove
-// stuff that shouldn't be processed.
-func (s *DefaultSyncer) shouldProcessResponse(resp *RespSync, since string) bool {
-	if since == "" {
-		return false
+	// Each room gets exactly one task so that state->timeline->ephemeral stays in order for that room, while
+	// different rooms are free to run on different workers.
+	for roomID, room := range joinRooms {
+		roomID, room := roomID, room
+		dispatchRoom(func() {
+			for _, event := range room.state {
+				event := event
+				event.Source = Join | State
+				s.updateRoomState(roomID, &event)
+				if !s.sendEvent(ctx, &event) {
+					return
+				}
+			}
+			for _, event := range room.timeline {
+				event := event
+				event.Source = Join | Timeline
+				if event.StateKey != nil {
+					s.updateRoomState(roomID, &event)
+				}
+				if s.IgnoreOwnEvents && event.Sender == s.UserID {
+					continue
+				}
+				if !s.sendEvent(ctx, &event) {
+					return
+				}
+			}
+			for _, event := range room.ephemeral {
+				event := event
+				event.Source = Join | Ephemeral
+				if !s.sendEvent(ctx, &event) {
+					return
+				}
+			}
+		})
 	}
-	// This is a horrible hack because /sync will return the most recent messages for a room
-	// as soon as you /join it. We do NOT want to process those events in that particular room
-	// because they may have already been processed (if you toggle the bot in/out of the room).
-	//
-	// Work around this by inspecting each room's timeline and seeing if an m.room.member event for us
-	// exists and is "join" and then discard processing that room entirely if so.
-	// TODO: We probably want to process messages from after the last join event in the timeline.
-	for roomID, roomData := range resp.Rooms.Join {
-		for i := len(roomData.Timeline.Events) - 1; i >= 0; i-- {
-			e := roomData.Timeline.Events[i]
-			if e.Type == MemberEventType && e.StateKey != nil && *e.StateKey == s.UserID {
-				m := e.Content["membership"]
-				mship, ok := m.(string)
-				if !ok {
+	for roomID, room := range inviteRooms {
+		roomID, room := roomID, room
+		dispatchRoom(func() {
+			for _, event := range room.state {
+				event := event
+				event.Source = Invite | State
+				s.updateRoomState(roomID, &event)
+				if !s.sendEvent(ctx, &event) {
+					return
+				}
+			}
+		})
+	}
+	for roomID, room := range leaveRooms {
+		roomID, room := roomID, room
+		dispatchRoom(func() {
+			for _, event := range room.state {
+				event := event
+				event.Source = Leave | State
+				s.updateRoomState(roomID, &event)
+				if !s.sendEvent(ctx, &event) {
+					return
+				}
+			}
+			for _, event := range room.timeline {
+				event := event
+				event.Source = Leave | Timeline
+				if event.StateKey != nil {
+					s.updateRoomState(roomID, &event)
+				}
+				if s.IgnoreOwnEvents && event.Sender == s.UserID {
 					continue
 				}
-				if mship == "join" {
-					_, ok = resp.Rooms.Join[roomID]
-					if !ok {
-						continue
-					}
-					delete(resp.Rooms.Join, roomID)   // don't re-process messages
-					delete(resp.Rooms.Invite, roomID) // don't re-process invites
-					break
+				if !s.sendEvent(ctx, &event) {
+					return
 				}
 			}
+		})
+	}
+
+	roomWG.Wait()
+	if firstRoomErr != nil {
+		err = firstRoomErr
+	}
+	return
+}
+
+// sendEvent delivers event on s.eventsChan, or returns false without sending if ctx is done first, so a
+// canceled or timed-out sync cannot block forever behind a listener that has stopped draining the channel.
+func (s *DefaultSyncer) sendEvent(ctx context.Context, event *Event) bool {
+	select {
+	case s.eventsChan <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// updateRoomState applies a state event to the cached Room for roomID before it is dispatched to listeners, so
+// a listener handling the event can already rely on Client.Room reflecting it. It is a no-op if no Store has
+// been configured.
+func (s *DefaultSyncer) updateRoomState(roomID string, event *Event) {
+	if s.Store == nil {
+		return
+	}
+	s.Store.GetOrCreateRoom(roomID).UpdateState(event)
+}
+
+// applyJoinRaceStrategy may trim or drop rooms from joinRooms (and the corresponding stale entry from
+// inviteRooms), depending on JoinRaceStrategy. See the JoinRaceStrategy doc comments for the behavior of each
+// strategy.
+func (s *DefaultSyncer) applyJoinRaceStrategy(joinRooms map[string]*decodedJoinRoom, inviteRooms map[string]*decodedInviteRoom) {
+	if s.JoinRaceStrategy == ProcessAll {
+		return
+	}
+	// /sync will return the most recent messages for a room as soon as you /join it, including messages sent
+	// before the join. Find the last self-join m.room.member event in each room's timeline, if any, and either
+	// drop the room entirely (DropRoom) or drop everything at-or-before that join (DropUpToJoin) so legitimate
+	// messages sent after the join are still processed.
+	for roomID, room := range joinRooms {
+		lastJoinIdx := -1
+		for i := len(room.timeline) - 1; i >= 0; i-- {
+			e := room.timeline[i]
+			if e.Type != MemberEventType || e.StateKey == nil || *e.StateKey != s.UserID {
+				continue
+			}
+			mship, ok := e.Content["membership"].(string)
+			if !ok || mship != "join" {
+				continue
+			}
+			lastJoinIdx = i
+			break
+		}
+		if lastJoinIdx == -1 {
+			continue
+		}
+		delete(inviteRooms, roomID) // we're no longer pending an invite once we've joined
+		if s.JoinRaceStrategy == DropRoom {
+			delete(joinRooms, roomID) // don't re-process messages
+			continue
 		}
+		room.timeline = room.timeline[lastJoinIdx+1:]
 	}
-	return true
 }
 
 // OnFailedSync always returns a 10 second wait period between failed /syncs, never a fatal error.
@@ -126,7 +406,18 @@ func (s *DefaultSyncer) OnFailedSync(res *RespSync, err error) (time.Duration, e
 	return 10 * time.Second, nil
 }
 
-// GetFilterJSON returns a filter with a timeline limit of 50.
+// GetFilterJSON marshals s.Filter, or a default filter with a room timeline limit of 50 if s.Filter is nil.
 func (s *DefaultSyncer) GetFilterJSON(userID string) json.RawMessage {
-	return json.RawMessage(`{"room":{"timeline":{"limit":50}}}`)
+	filter := s.Filter
+	if filter == nil {
+		filter = &Filter{Room: RoomFilter{Timeline: EventFilter{Limit: 50}}}
+	}
+	b, err := json.Marshal(filter)
+	if err != nil {
+		// Filter is built entirely from slices, bools and ints, so Marshal cannot realistically fail; fall
+		// back to the default filter written out by hand rather than propagating an error through a method
+		// that has no error return.
+		return json.RawMessage(`{"room":{"timeline":{"limit":50}}}`)
+	}
+	return b
 }
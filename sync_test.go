@@ -0,0 +1,209 @@
+package gomatrix
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func memberEvent(sender, stateKey, membership string) Event {
+	return Event{
+		Sender:   sender,
+		Type:     MemberEventType,
+		StateKey: &stateKey,
+		Content:  map[string]interface{}{"membership": membership},
+	}
+}
+
+func msgEvent(sender string) Event {
+	return Event{Sender: sender, Type: MessageEventType}
+}
+
+func TestApplyJoinRaceStrategy_DropUpToJoinKeepsEventsAfterLastSelfJoin(t *testing.T) {
+	userID := "@bot:example.org"
+	roomID := "!race:example.org"
+	joinRooms := map[string]*decodedJoinRoom{
+		roomID: {timeline: []Event{
+			msgEvent("@other:example.org"),
+			memberEvent(userID, userID, "join"),
+			msgEvent("@other:example.org"),
+			memberEvent(userID, userID, "join"), // rejoin later in the same timeline
+			msgEvent("@other:example.org"),      // only this one should survive
+		}},
+	}
+	s := NewDefaultSyncer(userID, make(chan *Event))
+
+	s.applyJoinRaceStrategy(joinRooms, map[string]*decodedInviteRoom{})
+
+	if got := len(joinRooms[roomID].timeline); got != 1 {
+		t.Fatalf("want 1 surviving event after the last self-join, got %d", got)
+	}
+}
+
+func TestApplyJoinRaceStrategy_InviteToJoinTransitionDropsInvite(t *testing.T) {
+	userID := "@bot:example.org"
+	roomID := "!race:example.org"
+	joinRooms := map[string]*decodedJoinRoom{
+		roomID: {timeline: []Event{
+			memberEvent(userID, userID, "join"),
+			msgEvent("@other:example.org"),
+		}},
+	}
+	inviteRooms := map[string]*decodedInviteRoom{roomID: {}}
+	s := NewDefaultSyncer(userID, make(chan *Event))
+
+	s.applyJoinRaceStrategy(joinRooms, inviteRooms)
+
+	if _, ok := inviteRooms[roomID]; ok {
+		t.Fatalf("invite for %s should have been dropped once the room was joined", roomID)
+	}
+	if got := len(joinRooms[roomID].timeline); got != 1 {
+		t.Fatalf("want 1 surviving timeline event, got %d", got)
+	}
+}
+
+func TestApplyJoinRaceStrategy_LeaveThenRejoinWithinOneSync(t *testing.T) {
+	userID := "@bot:example.org"
+	roomID := "!race:example.org"
+	joinRooms := map[string]*decodedJoinRoom{
+		roomID: {timeline: []Event{
+			memberEvent(userID, userID, "join"),
+			memberEvent(userID, userID, "leave"),
+			memberEvent(userID, userID, "join"), // rejoin
+			msgEvent("@other:example.org"),
+		}},
+	}
+	s := NewDefaultSyncer(userID, make(chan *Event))
+
+	s.applyJoinRaceStrategy(joinRooms, map[string]*decodedInviteRoom{})
+
+	timeline := joinRooms[roomID].timeline
+	if len(timeline) != 1 || timeline[0].Type != MessageEventType {
+		t.Fatalf("want only the post-rejoin message to survive, got %+v", timeline)
+	}
+}
+
+func TestApplyJoinRaceStrategy_DropRoomStrategyDropsWholeRoom(t *testing.T) {
+	userID := "@bot:example.org"
+	roomID := "!race:example.org"
+	joinRooms := map[string]*decodedJoinRoom{
+		roomID: {timeline: []Event{
+			memberEvent(userID, userID, "join"),
+			msgEvent("@other:example.org"),
+		}},
+	}
+	inviteRooms := map[string]*decodedInviteRoom{roomID: {}}
+	s := NewDefaultSyncer(userID, make(chan *Event))
+	s.JoinRaceStrategy = DropRoom
+
+	s.applyJoinRaceStrategy(joinRooms, inviteRooms)
+
+	if _, ok := joinRooms[roomID]; ok {
+		t.Fatalf("DropRoom should remove the room from joinRooms entirely")
+	}
+	if _, ok := inviteRooms[roomID]; ok {
+		t.Fatalf("DropRoom should remove the room from inviteRooms entirely")
+	}
+}
+
+// TestProcessResponse_UpdatesRoomStateFromTimelineMembershipEvents guards against the Room cache going stale
+// after the first sync: the "state" section of a /sync response only ever carries state predating the timeline
+// window, so ongoing membership changes arrive via "timeline" on every subsequent incremental sync.
+func TestProcessResponse_UpdatesRoomStateFromTimelineMembershipEvents(t *testing.T) {
+	roomID := "!race:example.org"
+	raw := `{
+		"next_batch": "s1",
+		"rooms": {
+			"join": {
+				"` + roomID + `": {
+					"timeline": {
+						"events": [
+							{"type":"m.room.member","sender":"@alice:example.org","state_key":"@alice:example.org","content":{"membership":"join"}}
+						]
+					}
+				}
+			}
+		}
+	}`
+	var resp RespSync
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		t.Fatalf("failed to build test RespSync: %s", err)
+	}
+
+	s := NewDefaultSyncer("@bot:example.org", make(chan *Event, 10))
+	s.Store = NewInMemoryStore()
+
+	if err := s.ProcessResponse(context.Background(), &resp, "s0"); err != nil {
+		t.Fatalf("ProcessResponse returned error: %s", err)
+	}
+
+	member := s.Store.GetRoom(roomID).GetMember("@alice:example.org")
+	if member == nil {
+		t.Fatalf("want Room state updated from a timeline membership event, got nil")
+	}
+	if mship, _ := member.Content["membership"].(string); mship != "join" {
+		t.Fatalf("want cached membership %q, got %q", "join", mship)
+	}
+}
+
+// TestProcessResponse_DispatchesLeaveRoomStateEvents guards against SyncLeaveRoom.State being decoded but
+// never dispatched, which would silently drop state changes (e.g. a late rename) for a room the user has left.
+func TestProcessResponse_DispatchesLeaveRoomStateEvents(t *testing.T) {
+	roomID := "!left:example.org"
+	raw := `{
+		"next_batch": "s1",
+		"rooms": {
+			"leave": {
+				"` + roomID + `": {
+					"state": {
+						"events": [
+							{"type":"m.room.name","sender":"@alice:example.org","state_key":"","content":{"name":"Renamed after I left"}}
+						]
+					}
+				}
+			}
+		}
+	}`
+	var resp RespSync
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil {
+		t.Fatalf("failed to build test RespSync: %s", err)
+	}
+
+	eventsChan := make(chan *Event, 10)
+	s := NewDefaultSyncer("@bot:example.org", eventsChan)
+
+	if err := s.ProcessResponse(context.Background(), &resp, "s0"); err != nil {
+		t.Fatalf("ProcessResponse returned error: %s", err)
+	}
+	close(eventsChan)
+
+	var got *Event
+	for e := range eventsChan {
+		got = e
+	}
+	if got == nil {
+		t.Fatalf("want the leave room's state event to be dispatched, got none")
+	}
+	if got.Source != Leave|State {
+		t.Fatalf("want Source Leave|State, got %v", got.Source)
+	}
+}
+
+func TestApplyJoinRaceStrategy_ProcessAllLeavesTimelineUntouched(t *testing.T) {
+	userID := "@bot:example.org"
+	roomID := "!race:example.org"
+	joinRooms := map[string]*decodedJoinRoom{
+		roomID: {timeline: []Event{
+			memberEvent(userID, userID, "join"),
+			msgEvent("@other:example.org"),
+		}},
+	}
+	s := NewDefaultSyncer(userID, make(chan *Event))
+	s.JoinRaceStrategy = ProcessAll
+
+	s.applyJoinRaceStrategy(joinRooms, map[string]*decodedInviteRoom{})
+
+	if got := len(joinRooms[roomID].timeline); got != 2 {
+		t.Fatalf("ProcessAll should not modify the timeline, got %d events", got)
+	}
+}
@@ -0,0 +1,97 @@
+package gomatrix
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// workerIdleTimeout is how long a pool worker waits for a new task before exiting, so a burst of activity
+// (e.g. a bot joining hundreds of rooms at once) doesn't leave idle goroutines running forever.
+const workerIdleTimeout = 10 * time.Second
+
+// roomTaskPool runs per-room sync tasks across a bounded number of workers, so a slow listener in one room
+// cannot stall event dispatch for every other room. Workers are spawned lazily, up to maxWorkers, and idle
+// out after workerIdleTimeout of inactivity. Submitting blocks once queueSize tasks are already buffered,
+// providing back-pressure instead of unbounded memory growth.
+type roomTaskPool struct {
+	maxWorkers int
+	tasks      chan func()
+	active     int32 // atomic count of live workers, always <= maxWorkers
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+func newRoomTaskPool(maxWorkers, queueSize int) *roomTaskPool {
+	if maxWorkers <= 0 {
+		maxWorkers = 1
+	}
+	if queueSize <= 0 {
+		queueSize = maxWorkers
+	}
+	return &roomTaskPool{
+		maxWorkers: maxWorkers,
+		tasks:      make(chan func(), queueSize),
+	}
+}
+
+// submit enqueues task, spawning another worker if the pool has not yet reached maxWorkers. It blocks if the
+// task queue is full, which is the pool's back-pressure mechanism, or returns false without enqueuing task if
+// ctx is done first, so a canceled sync cannot wedge forever behind a full queue.
+func (p *roomTaskPool) submit(ctx context.Context, task func()) bool {
+	p.maybeSpawnWorker()
+	select {
+	case p.tasks <- task:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (p *roomTaskPool) maybeSpawnWorker() {
+	for {
+		current := atomic.LoadInt32(&p.active)
+		if current >= int32(p.maxWorkers) {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&p.active, current, current+1) {
+			p.wg.Add(1)
+			go p.runWorker()
+			return
+		}
+	}
+}
+
+func (p *roomTaskPool) runWorker() {
+	defer p.wg.Done()
+	defer atomic.AddInt32(&p.active, -1)
+
+	idle := time.NewTimer(workerIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case task, ok := <-p.tasks:
+			if !ok {
+				return
+			}
+			task()
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(workerIdleTimeout)
+		case <-idle.C:
+			return
+		}
+	}
+}
+
+// stop closes the task queue and waits for every in-flight and already-queued task to finish, so callers can
+// rely on a clean shutdown instead of abandoning goroutines mid-dispatch.
+func (p *roomTaskPool) stop() {
+	p.closeOnce.Do(func() {
+		close(p.tasks)
+	})
+	p.wg.Wait()
+}